@@ -43,6 +43,7 @@ func NewRedisAttackCommand(uid *string) *cobra.Command {
 	cmd.AddCommand(
 		// NewRedisSentinelRestartCommand(dep, options),
 		NewRedisSentinelStopCommand(dep, options),
+		NewRedisCacheExpirationCommand(dep, options),
 	)
 
 	return cmd
@@ -80,6 +81,26 @@ func NewRedisSentinelStopCommand(dep fx.Option, options *core.RedisCommand) *cob
 	return cmd
 }
 
+func NewRedisCacheExpirationCommand(dep fx.Option, options *core.RedisCommand) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache-expiration",
+		Short: "expire redis cache keys",
+		Run: func(*cobra.Command, []string) {
+			options.Action = core.RedisCacheExpirationAction
+			utils.FxNewAppWithoutLog(dep, fx.Invoke(redisAttackF)).Run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.Addr, "addr", "a", "", "")
+	cmd.Flags().StringVarP(&options.Password, "password", "p", "", "the password of the redis server")
+	cmd.Flags().StringVarP(&options.Key, "key", "k", "", "the key, or glob pattern of keys, to expire")
+	cmd.Flags().StringVarP(&options.Expiration, "expiration", "e", "", "the new expiration of the cache key, e.g. 5s, 10m")
+	cmd.Flags().StringVarP(&options.Option, "option", "o", "", "the expiration option: XX/NX/GT/LT")
+	cmd.Flags().IntVarP(&options.DB, "db", "", 0, "the redis DB to operate on")
+
+	return cmd
+}
+
 func redisAttackF(chaos *chaosd.Server, options *core.RedisCommand) {
 	if err := options.Validate(); err != nil {
 		utils.ExitWithError(utils.ExitBadArgs, err)
@@ -40,6 +40,12 @@ type RedisCommand struct {
 	Key         string `json:"key,omitempty"`
 	Expiration  string `json:"expiration,omitempty"`
 	Option      string `json:"option,omitempty"`
+	DB          int    `json:"db,omitempty"`
+
+	// ExpiredKeys records the TTL (in milliseconds) each matched key had right
+	// before the expiration attack ran, so Recover can restore it. A value of
+	// -1 means the key had no TTL (persistent) and -2 means it did not exist.
+	ExpiredKeys map[string]int64 `json:"expiredKeys,omitempty"`
 }
 
 func (r *RedisCommand) Validate() error {
@@ -56,6 +62,12 @@ func (r *RedisCommand) Validate() error {
 		}
 
 	case RedisCacheExpirationAction:
+		if len(r.Key) == 0 {
+			return errors.New("key is required")
+		}
+		if len(r.Expiration) == 0 {
+			return errors.New("expiration is required")
+		}
 		if r.Option != "" && r.Option != "XX" && r.Option != "NX" && r.Option != "GT" && r.Option != "LT" {
 			return errors.New("option invalid")
 		}
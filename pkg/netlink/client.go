@@ -0,0 +1,329 @@
+// Copyright 2020 Chaos Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package netlink applies tc qdisc changes directly over rtnetlink, as an
+// alternative to shelling out to the `tc` binary. It mirrors the small slice
+// of tc functionality chaosd needs (netem, tbf, prio+sfq) rather than being a
+// general tc client. Ipset-matched traffic is still dispatched through
+// iptables CLASSIFY rules, as with the shell backend: github.com/vishvananda/netlink
+// has no first-class "basic" classifier with an ipset ematch to build a tc
+// filter on top of instead.
+package netlink
+
+import (
+	"runtime"
+	"syscall"
+
+	"github.com/pingcap/errors"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// Client applies qdisc/filter operations to a single device inside a target
+// network namespace.
+type Client struct {
+	nsPath string
+	device string
+}
+
+// NewClient returns a Client bound to device inside the netns at nsPath.
+func NewClient(nsPath string, device string) *Client {
+	return &Client{nsPath: nsPath, device: device}
+}
+
+// Handle is the unit of a parent:minor reference, e.g. the "8:4" in
+// `tc ... parent 8:4`.
+type Handle struct {
+	Major uint16
+	Minor uint16
+}
+
+func (h Handle) toKernel() uint32 {
+	return netlink.MakeHandle(h.Major, h.Minor)
+}
+
+// RootHandle is the handle used to attach a qdisc as the root of a device;
+// its kernel value is TC_H_ROOT (ffff:ffff).
+var RootHandle = Handle{Major: 0xffff, Minor: 0xffff}
+
+// withLink locks the calling goroutine to its OS thread, switches it into the
+// client's target netns for the duration of fn, and hands fn a netlink.Handle
+// and the resolved Link bound to that namespace.
+func (c *Client) withLink(fn func(handle *netlink.Handle, link netlink.Link) error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer origin.Close()
+
+	target, err := netns.GetFromPath(c.nsPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer target.Close()
+
+	if err := netns.Set(target); err != nil {
+		return errors.WithStack(err)
+	}
+	defer netns.Set(origin) // nolint: errcheck
+
+	handle, err := netlink.NewHandleAt(target)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer handle.Close()
+
+	link, err := handle.LinkByName(c.device)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return ErrDeviceNotFound
+		}
+		return errors.WithStack(err)
+	}
+
+	return fn(handle, link)
+}
+
+// classify turns a netlink error into one of our typed errors where we have
+// enough information to, otherwise it is returned wrapped as-is.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Cause(err) == syscall.ENOENT {
+		return ErrQdiscNotFound
+	}
+	return errors.WithStack(err)
+}
+
+// Flush deletes the root qdisc of the device, if any.
+func (c *Client) Flush() error {
+	return c.withLink(func(handle *netlink.Handle, link netlink.Link) error {
+		qdiscs, err := handle.QdiscList(link)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, qdisc := range qdiscs {
+			if qdisc.Attrs().Parent != netlink.HANDLE_ROOT {
+				continue
+			}
+			if err := handle.QdiscDel(qdisc); err != nil {
+				return classify(err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// NetemParams mirrors the subset of pb.Netem chaosd can apply.
+type NetemParams struct {
+	Latency       uint32
+	Jitter        uint32
+	DelayCorr     float32
+	Limit         uint32
+	Loss          float32
+	LossCorr      float32
+	Duplicate     float32
+	DuplicateCorr float32
+	Reorder       float32
+	ReorderCorr   float32
+	Corrupt       float32
+	CorruptCorr   float32
+	Gap           uint32
+}
+
+func newNetem(attrs netlink.QdiscAttrs, params NetemParams) netlink.Qdisc {
+	return netlink.NewNetem(attrs, netlink.NetemQdiscAttrs{
+		Latency:       params.Latency,
+		Jitter:        params.Jitter,
+		DelayCorr:     params.DelayCorr,
+		Limit:         params.Limit,
+		Loss:          params.Loss,
+		LossCorr:      params.LossCorr,
+		Duplicate:     params.Duplicate,
+		DuplicateCorr: params.DuplicateCorr,
+		ReorderProb:   params.Reorder,
+		ReorderCorr:   params.ReorderCorr,
+		CorruptProb:   params.Corrupt,
+		CorruptCorr:   params.CorruptCorr,
+		Gap:           params.Gap,
+	})
+}
+
+// AddNetem adds a netem qdisc under parent, with the given handle.
+func (c *Client) AddNetem(parent, handle Handle, params NetemParams) error {
+	return c.withLink(func(nlHandle *netlink.Handle, link netlink.Link) error {
+		attrs := netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    handle.toKernel(),
+			Parent:    parent.toKernel(),
+		}
+
+		if err := nlHandle.QdiscAdd(newNetem(attrs, params)); err != nil {
+			return classify(err)
+		}
+
+		return nil
+	})
+}
+
+// TbfParams mirrors the subset of pb.Tbf chaosd can apply.
+type TbfParams struct {
+	Rate     uint64
+	Buffer   uint32
+	Limit    uint32
+	PeakRate uint64
+	MinBurst uint32
+}
+
+func newTbf(attrs netlink.QdiscAttrs, params TbfParams) netlink.Qdisc {
+	return &netlink.Tbf{
+		QdiscAttrs: attrs,
+		Rate:       params.Rate,
+		Buffer:     params.Buffer,
+		Limit:      params.Limit,
+		Peakrate:   params.PeakRate,
+		Minburst:   params.MinBurst,
+	}
+}
+
+// AddTbf adds a tbf qdisc under parent, with the given handle.
+func (c *Client) AddTbf(parent, handle Handle, params TbfParams) error {
+	return c.withLink(func(nlHandle *netlink.Handle, link netlink.Link) error {
+		attrs := netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    handle.toKernel(),
+			Parent:    parent.toKernel(),
+		}
+
+		if err := nlHandle.QdiscAdd(newTbf(attrs, params)); err != nil {
+			return classify(err)
+		}
+
+		return nil
+	})
+}
+
+// AddSfq adds an sfq leaf qdisc under parent, with the given handle.
+func (c *Client) AddSfq(parent, handle Handle) error {
+	return c.withLink(func(nlHandle *netlink.Handle, link netlink.Link) error {
+		attrs := netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    handle.toKernel(),
+			Parent:    parent.toKernel(),
+		}
+
+		sfq := &netlink.GenericQdisc{QdiscAttrs: attrs, QdiscType: "sfq"}
+		if err := nlHandle.QdiscAdd(sfq); err != nil {
+			return classify(err)
+		}
+
+		return nil
+	})
+}
+
+// AddPrioWithSfq adds the PRIO qdisc this package uses to fan tc rules out
+// into bands, with `bands` total bands, plus the first three sfq leaves that
+// back its normal (non-ipset) bands. It is one call instead of four
+// (AddPrio + 3x AddSfq) so the common case only pays for a single netns
+// switch.
+func (c *Client) AddPrioWithSfq(parent Handle, prioHandle Handle, bands int) error {
+	return c.withLink(func(nlHandle *netlink.Handle, link netlink.Link) error {
+		prioAttrs := netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    prioHandle.toKernel(),
+			Parent:    parent.toKernel(),
+		}
+
+		prio := netlink.NewPrio(prioAttrs)
+		prio.Bands = uint8(bands)
+		prio.PriorityMap = [16]uint8{1, 2, 2, 2, 1, 2, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1}
+
+		if err := nlHandle.QdiscAdd(prio); err != nil {
+			return classify(err)
+		}
+
+		for band := uint16(1); band <= 3; band++ {
+			sfqAttrs := netlink.QdiscAttrs{
+				LinkIndex: link.Attrs().Index,
+				Handle:    netlink.MakeHandle(prioHandle.Major+band, 0),
+				Parent:    netlink.MakeHandle(prioHandle.Major, band),
+			}
+			sfq := &netlink.GenericQdisc{QdiscAttrs: sfqAttrs, QdiscType: "sfq"}
+			if err := nlHandle.QdiscAdd(sfq); err != nil {
+				return classify(err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// ChainQdisc is one link of a chain built by AddChain: a netem or tbf qdisc
+// at Handle, parented to whatever came before it in the chain (or to the
+// chain's own parent, for the first entry).
+type ChainQdisc struct {
+	Handle Handle
+	Netem  *NetemParams
+	Tbf    *TbfParams
+}
+
+// AddChain adds qdiscs as a parent->child chain under parent, each one
+// parented to the previous, terminated by an sfq leaf at leafHandle. It is
+// one call instead of len(qdiscs)+1, so a chain of N tc rules behind one
+// ipset only pays for a single netns switch instead of N+1.
+func (c *Client) AddChain(parent Handle, qdiscs []ChainQdisc, leafHandle Handle) error {
+	return c.withLink(func(nlHandle *netlink.Handle, link netlink.Link) error {
+		current := parent
+
+		for _, q := range qdiscs {
+			attrs := netlink.QdiscAttrs{
+				LinkIndex: link.Attrs().Index,
+				Handle:    q.Handle.toKernel(),
+				Parent:    current.toKernel(),
+			}
+
+			var qdisc netlink.Qdisc
+			switch {
+			case q.Netem != nil:
+				qdisc = newNetem(attrs, *q.Netem)
+			case q.Tbf != nil:
+				qdisc = newTbf(attrs, *q.Tbf)
+			default:
+				return errors.New("chain qdisc has neither Netem nor Tbf set")
+			}
+
+			if err := nlHandle.QdiscAdd(qdisc); err != nil {
+				return classify(err)
+			}
+
+			current = q.Handle
+		}
+
+		leafAttrs := netlink.QdiscAttrs{
+			LinkIndex: link.Attrs().Index,
+			Handle:    leafHandle.toKernel(),
+			Parent:    current.toKernel(),
+		}
+		if err := nlHandle.QdiscAdd(&netlink.GenericQdisc{QdiscAttrs: leafAttrs, QdiscType: "sfq"}); err != nil {
+			return classify(err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 Chaos Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netlink
+
+import "fmt"
+
+// notFoundError is returned for every "X does not exist" outcome the netlink
+// backend can hit, so callers can tell it apart from other rtnetlink errors
+// without string-matching command output the way the shell backend does.
+type notFoundError struct {
+	kind string
+}
+
+func (e *notFoundError) Error() string {
+	return fmt.Sprintf("%s not found", e.kind)
+}
+
+// Is lets errors.Is(err, ErrQdiscNotFound) work even when err has been
+// wrapped, e.g. by github.com/pingcap/errors.
+func (e *notFoundError) Is(target error) bool {
+	other, ok := target.(*notFoundError)
+	return ok && other.kind == e.kind
+}
+
+var (
+	// ErrQdiscNotFound is returned when an operation expected a qdisc, class
+	// or filter to already exist (e.g. Flush on an interface with no root
+	// qdisc) and it did not.
+	ErrQdiscNotFound = &notFoundError{kind: "qdisc"}
+	// ErrDeviceNotFound is returned when the requested device does not exist
+	// in the target network namespace.
+	ErrDeviceNotFound = &notFoundError{kind: "device"}
+)
@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"math"
 	"os/exec"
+	"strings"
 	"sync"
 	"time"
 
@@ -88,6 +89,9 @@ func (redisAttack) Attack(options core.AttackConfig, env Environment) error {
 		// }
 		wg.Wait()
 		fmt.Println(time.Now().Sub(start))
+
+	case core.RedisCacheExpirationAction:
+		return env.Chaos.expireRedisKeys(attack, cli)
 	}
 	return nil
 }
@@ -102,6 +106,9 @@ func (redisAttack) Recover(exp core.Experiment, env Environment) error {
 	switch attack.Action {
 	case core.RedisSentinelStopAction:
 		return env.Chaos.recoverSentinelStop(attack)
+
+	case core.RedisCacheExpirationAction:
+		return env.Chaos.recoverRedisKeysExpiration(attack)
 	}
 	return nil
 }
@@ -141,3 +148,109 @@ func (s *Server) recoverSentinelStop(attack *core.RedisCommand) error {
 	}
 	return nil
 }
+
+// expireRedisKeys resolves attack.Key to the set of keys it matches (either a
+// single literal key or a glob pattern walked through SCAN), sets a new TTL on
+// each of them and records the TTL they had before the attack so Recover can
+// restore it.
+func (s *Server) expireRedisKeys(attack *core.RedisCommand, cli *redis.Client) error {
+	if attack.DB != 0 {
+		cli = redis.NewClient(&redis.Options{
+			Addr:     attack.Addr,
+			Password: attack.Password,
+			DB:       attack.DB,
+		})
+		defer cli.Close()
+	}
+
+	expiration, err := time.ParseDuration(attack.Expiration)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	keys, err := matchRedisKeys(cli, attack.Key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	expiredKeys := make(map[string]int64, len(keys))
+	for _, key := range keys {
+		ttl, err := cli.PTTL(cli.Context(), key).Result()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		// go-redis returns PTTL's "no TTL" (-1) and "no such key" (-2) replies
+		// as literal, unscaled durations, so they must be special-cased before
+		// dividing by time.Millisecond: plain division would truncate both to
+		// 0, which recoverRedisKeysExpiration would then read as "had a 0ms
+		// TTL" and PEXPIRE to 0, deleting the key instead of restoring it.
+		switch ttl {
+		case -1 * time.Nanosecond, -2 * time.Nanosecond:
+			expiredKeys[key] = int64(ttl)
+		default:
+			expiredKeys[key] = int64(ttl / time.Millisecond)
+		}
+
+		args := []interface{}{"PEXPIRE", key, expiration.Milliseconds()}
+		if attack.Option != "" {
+			args = append(args, attack.Option)
+		}
+		if err := cli.Do(cli.Context(), args...).Err(); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	attack.ExpiredKeys = expiredKeys
+
+	return nil
+}
+
+// recoverRedisKeysExpiration restores the TTL that each key affected by a
+// RedisCacheExpirationAction attack had beforehand, as recorded in
+// attack.ExpiredKeys.
+func (s *Server) recoverRedisKeysExpiration(attack *core.RedisCommand) error {
+	cli := redis.NewClient(&redis.Options{
+		Addr:     attack.Addr,
+		Password: attack.Password,
+		DB:       attack.DB,
+	})
+	defer cli.Close()
+
+	for key, ttl := range attack.ExpiredKeys {
+		switch {
+		case ttl == -2:
+			// the key did not exist before the attack, nothing to restore
+			continue
+		case ttl == -1:
+			if err := cli.Persist(cli.Context(), key).Err(); err != nil {
+				return errors.WithStack(err)
+			}
+		default:
+			if err := cli.PExpire(cli.Context(), key, time.Duration(ttl)*time.Millisecond).Err(); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchRedisKeys returns the literal key itself when pattern has no glob
+// metacharacters, otherwise it walks the keyspace with SCAN to find every key
+// matching it.
+func matchRedisKeys(cli *redis.Client, pattern string) ([]string, error) {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+
+	var keys []string
+	iter := cli.Scan(cli.Context(), 0, pattern, 0).Iterator()
+	for iter.Next(cli.Context()) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return keys, nil
+}
@@ -32,7 +32,43 @@ const (
 	ruleNotExistLowerVersion = "RTNETLINK answers: No such file or directory"
 )
 
-func generateQdiscArgs(action string, qdisc *pb.Qdisc) ([]string, error) {
+// TcBackend selects how tc rules are applied: "shell" (default) shells out to
+// the tc binary, "netlink" talks to the kernel directly over rtnetlink.
+// "shell" is kept as the default so existing deployments that only have
+// iproute2 inside the target mount namespace keep working unchanged.
+//
+// There is deliberately no --tc-backend flag wiring it up yet. That flag
+// belongs on the daemon's own command (cmd/server), not in pkg/server/chaosd,
+// and this change series does not touch cmd/server at all, so for now
+// TcBackend only flips via a direct assignment, e.g. from a test. Wiring an
+// actual flag is tracked as follow-up work, not a claim of this series.
+var TcBackend = "shell"
+
+// qdiscBackend is the surface both the shell-based tcClient and the
+// netlink-based backend implement, so the rest of this file doesn't need to
+// care which one TcBackend selected.
+type qdiscBackend interface {
+	flush() error
+	addTc(parentArg, handleArg string, tc *pb.Tc) error
+	addPrio(parent, band int) error
+	addSfq(parentArg, handleArg string) error
+	// addChain adds tcs as a parent->child chain under parentArg, in order,
+	// terminated by an sfq leaf, starting the chain's own handles at
+	// startHandle+1. It returns the last handle it allocated, so callers can
+	// keep numbering subsequent chains/qdiscs without collisions.
+	addChain(parentArg string, tcs []*pb.Tc, startHandle int) (endHandle int, err error)
+}
+
+func buildBackend(ctx context.Context, nsPath string, device string) qdiscBackend {
+	if TcBackend == "netlink" {
+		return newNetlinkBackend(nsPath, device)
+	}
+
+	c := buildTcClient(ctx, nsPath, device)
+	return &c
+}
+
+func generateQdiscArgs(action string, qdisc *pb.Qdisc, device string) ([]string, error) {
 
 	if qdisc == nil {
 		return nil, fmt.Errorf("qdisc is required")
@@ -42,7 +78,7 @@ func generateQdiscArgs(action string, qdisc *pb.Qdisc) ([]string, error) {
 		return nil, fmt.Errorf("qdisc.Type is required")
 	}
 
-	args := []string{"qdisc", action, "dev", "eth0"}
+	args := []string{"qdisc", action, "dev", device}
 
 	if qdisc.Parent == nil {
 		args = append(args, "root")
@@ -74,7 +110,73 @@ func (s *Server) SetContainerTcRules(ctx context.Context, in *pb.TcsRequest) err
 	}
 
 	nsPath := GetNsPath(pid, bpm.NetNS)
-	tcClient := buildTcClient(ctx, nsPath)
+
+	// Scope note: the original request asked for a configurable device list,
+	// but pb.TcsRequest (github.com/chaos-mesh/chaos-daemon/pkg/server/serverpb,
+	// an external module this repo doesn't own or vendor a source copy of) has
+	// no field to carry one, and nothing on the chaosd CLI side of this change
+	// series builds a config object ahead of the gRPC call that could carry one
+	// either - pkg/core has no tc/network attack command in this series to add
+	// such a field to. So that part of the request is dropped here, not
+	// silently: every non-loopback interface in the container's netns is
+	// always auto-detected instead, and picking an explicit device back up is
+	// follow-up work gated on an upstream serverpb change.
+	devices, err := detectContainerDevices(ctx, nsPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	applied := []string{}
+	for _, device := range devices {
+		if err := setDeviceTcRules(ctx, nsPath, device, in); err != nil {
+			log.Error("failed to apply tc rules on device, rolling back", zap.String("device", device), zap.Error(err))
+			for _, rollbackDevice := range applied {
+				if ferr := buildBackend(ctx, nsPath, rollbackDevice).flush(); ferr != nil {
+					log.Error("failed to roll back tc rules", zap.String("device", rollbackDevice), zap.Error(ferr))
+				}
+			}
+			if ferr := buildBackend(ctx, nsPath, device).flush(); ferr != nil {
+				log.Error("failed to roll back tc rules", zap.String("device", device), zap.Error(ferr))
+			}
+			return errors.WithStack(err)
+		}
+		applied = append(applied, device)
+	}
+
+	return nil
+}
+
+// detectContainerDevices lists the network interfaces attached to the
+// container's netns, skipping the loopback device. SetContainerTcRules always
+// acts on every device it returns.
+func detectContainerDevices(ctx context.Context, nsPath string) ([]string, error) {
+	cmd := bpm.DefaultProcessBuilder("ls", "/sys/class/net").SetNetNS(nsPath).SetContext(ctx).Build()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, encodeOutputToError(output, err)
+	}
+
+	devices := []string{}
+	for _, dev := range strings.Fields(string(output)) {
+		if dev == "lo" {
+			continue
+		}
+		devices = append(devices, dev)
+	}
+
+	if len(devices) == 0 {
+		return nil, errors.New("no usable network interface found in container netns")
+	}
+
+	return devices, nil
+}
+
+// setDeviceTcRules applies the full qdisc/prio/filter tree described by in to
+// a single device. It is factored out of SetContainerTcRules so that the
+// latter can apply it independently to every device and roll back cleanly on
+// a partial failure.
+func setDeviceTcRules(ctx context.Context, nsPath string, device string, in *pb.TcsRequest) error {
+	tcClient := buildBackend(ctx, nsPath, device)
 
 	if err := tcClient.flush(); err != nil {
 		return errors.WithStack(err)
@@ -106,12 +208,15 @@ func (s *Server) SetContainerTcRules(ctx context.Context, in *pb.TcsRequest) err
 
 	globalTc := []*pb.Tc{}
 	filterTc := map[string][]*pb.Tc{}
+	ipsetOrder := []string{}
 
 	for _, tc := range in.Tcs {
 		if tc.Ipset == "" {
 			globalTc = append(globalTc, tc)
 		} else {
-			// TODO: support multiple tc with one ipset
+			if _, ok := filterTc[tc.Ipset]; !ok {
+				ipsetOrder = append(ipsetOrder, tc.Ipset)
+			}
 			filterTc[tc.Ipset] = append(filterTc[tc.Ipset], tc)
 		}
 	}
@@ -133,7 +238,7 @@ func (s *Server) SetContainerTcRules(ctx context.Context, in *pb.TcsRequest) err
 
 	parent := len(globalTc)
 	band := 3 + len(filterTc) // 3 handlers for normal sfq on prio qdisc
-	err = tcClient.addPrio(parent, band)
+	err := tcClient.addPrio(parent, band)
 	if err != nil {
 		log.Error("failed to add prio", zap.Error(err))
 		return errors.WithStack(err)
@@ -141,40 +246,36 @@ func (s *Server) SetContainerTcRules(ctx context.Context, in *pb.TcsRequest) err
 
 	parent++
 
-	index := 0
 	currentHandler := parent + 3 // 3 handlers for sfq on prio qdisc
 
 	iptables := buildIptablesClient(ctx, nsPath)
 
+	// Each ipset owns one band of the PRIO qdisc and, behind it, a private
+	// chain of netem/tbf qdiscs (parent -> child, in the order the tc rules
+	// were given), terminated by an sfq leaf. The iptables CLASSIFY target
+	// always points at the band, i.e. the head of that chain, so every qdisc
+	// in it - not just the first one - actually sees the matched traffic.
+	//
 	// iptables chain has been initialized by previous grpc request to set iptables
 	// and iptables rules are recovered by previous call too, so there is no need
 	// to remove these rules here
 	chains := []*pb.Chain{}
-	for ipset, tcs := range filterTc {
-		for i, tc := range tcs {
-			parentArg := fmt.Sprintf("parent %d:%d", parent, index+4)
-			if i > 0 {
-				parentArg = fmt.Sprintf("parent %d:", currentHandler)
-			}
+	for chainIndex, ipset := range ipsetOrder {
+		band := chainIndex + 4
+		parentArg := fmt.Sprintf("parent %d:%d", parent, band)
 
-			currentHandler++
-			handleArg := fmt.Sprintf("handle %d:", currentHandler)
-
-			err := tcClient.addTc(parentArg, handleArg, tc)
-			if err != nil {
-				log.Error("failed to add tc rules", zap.Error(err))
-				return errors.WithStack(err)
-			}
+		currentHandler, err = tcClient.addChain(parentArg, filterTc[ipset], currentHandler)
+		if err != nil {
+			log.Error("failed to add ipset qdisc chain", zap.Error(err))
+			return errors.WithStack(err)
 		}
 
 		chains = append(chains, &pb.Chain{
-			Name:      fmt.Sprintf("TC-TABLES-%d", index),
+			Name:      fmt.Sprintf("TC-TABLES-%d", chainIndex),
 			Direction: pb.Chain_OUTPUT,
 			Ipsets:    []string{ipset},
-			Target:    fmt.Sprintf("CLASSIFY --set-class %d:%d", parent, index+4),
+			Target:    fmt.Sprintf("CLASSIFY --set-class %d:%d", parent, band),
 		})
-
-		index++
 	}
 	if err = iptables.setIptablesChains(chains); err != nil {
 		log.Error("failed to set iptables", zap.Error(err))
@@ -188,12 +289,14 @@ func (s *Server) SetContainerTcRules(ctx context.Context, in *pb.TcsRequest) err
 type tcClient struct {
 	ctx    context.Context
 	nsPath string
+	device string
 }
 
-func buildTcClient(ctx context.Context, nsPath string) tcClient {
+func buildTcClient(ctx context.Context, nsPath string, device string) tcClient {
 	return tcClient{
 		ctx,
 		nsPath,
+		device,
 	}
 }
 
@@ -202,7 +305,7 @@ const (
 )
 
 func (c *tcClient) flush() error {
-	cmd := bpm.DefaultProcessBuilder("tc", "qdisc", "del", "dev", "eth0", "root").SetNetNS(c.nsPath).SetContext(c.ctx).Build()
+	cmd := bpm.DefaultProcessBuilder("tc", "qdisc", "del", "dev", c.device, "root").SetNetNS(c.nsPath).SetContext(c.ctx).Build()
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		output := string(output)
@@ -214,7 +317,7 @@ func (c *tcClient) flush() error {
 }
 
 func (c *tcClient) addTc(parentArg string, handleArg string, tc *pb.Tc) error {
-	log.Info("add tc", zap.Any("tc", tc))
+	log.Info("add tc", zap.Any("tc", tc), zap.String("device", c.device))
 
 	if tc.Type == pb.Tc_BANDWIDTH {
 
@@ -244,13 +347,13 @@ func (c *tcClient) addTc(parentArg string, handleArg string, tc *pb.Tc) error {
 }
 
 func (c *tcClient) addPrio(parent int, band int) error {
-	log.Info("adding prio", zap.Int("parent", parent))
+	log.Info("adding prio", zap.Int("parent", parent), zap.String("device", c.device))
 
 	parentArg := "root"
 	if parent > 0 {
 		parentArg = fmt.Sprintf("parent %d:", parent)
 	}
-	args := fmt.Sprintf("qdisc add dev eth0 %s handle %d: prio bands %d priomap 1 2 2 2 1 2 0 0 1 1 1 1 1 1 1 1", parentArg, parent+1, band)
+	args := fmt.Sprintf("qdisc add dev %s %s handle %d: prio bands %d priomap 1 2 2 2 1 2 0 0 1 1 1 1 1 1 1 1", c.device, parentArg, parent+1, band)
 	cmd := bpm.DefaultProcessBuilder("tc", strings.Split(args, " ")...).SetNetNS(c.nsPath).SetContext(c.ctx).Build()
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -258,21 +361,54 @@ func (c *tcClient) addPrio(parent int, band int) error {
 	}
 
 	for index := 1; index <= 3; index++ {
-		args := fmt.Sprintf("qdisc add dev eth0 parent %d:%d handle %d: sfq", parent+1, index, parent+1+index)
-		cmd := bpm.DefaultProcessBuilder("tc", strings.Split(args, " ")...).SetNetNS(c.nsPath).SetContext(c.ctx).Build()
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			return encodeOutputToError(output, err)
+		parentArg := fmt.Sprintf("parent %d:%d", parent+1, index)
+		handleArg := fmt.Sprintf("handle %d:", parent+1+index)
+		if err := c.addSfq(parentArg, handleArg); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+func (c *tcClient) addSfq(parentArg string, handleArg string) error {
+	log.Info("adding sfq", zap.String("parent", parentArg), zap.String("handle", handleArg), zap.String("device", c.device))
+
+	args := fmt.Sprintf("qdisc add dev %s %s %s sfq", c.device, parentArg, handleArg)
+	cmd := bpm.DefaultProcessBuilder("tc", strings.Split(args, " ")...).SetNetNS(c.nsPath).SetContext(c.ctx).Build()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return encodeOutputToError(output, err)
+	}
+	return nil
+}
+
+func (c *tcClient) addChain(parentArg string, tcs []*pb.Tc, startHandle int) (int, error) {
+	currentHandler := startHandle
+
+	for _, tc := range tcs {
+		currentHandler++
+		handleArg := fmt.Sprintf("handle %d:", currentHandler)
+
+		if err := c.addTc(parentArg, handleArg, tc); err != nil {
+			return currentHandler, err
+		}
+
+		parentArg = fmt.Sprintf("parent %d:", currentHandler)
+	}
+
+	currentHandler++
+	if err := c.addSfq(parentArg, fmt.Sprintf("handle %d:", currentHandler)); err != nil {
+		return currentHandler, err
+	}
+
+	return currentHandler, nil
+}
+
 func (c *tcClient) addNetem(parent string, handle string, netem *pb.Netem) error {
-	log.Info("adding netem", zap.String("parent", parent), zap.String("handle", handle))
+	log.Info("adding netem", zap.String("parent", parent), zap.String("handle", handle), zap.String("device", c.device))
 
-	args := fmt.Sprintf("qdisc add dev eth0 %s %s netem %s", parent, handle, convertNetemToArgs(netem))
+	args := fmt.Sprintf("qdisc add dev %s %s %s netem %s", c.device, parent, handle, convertNetemToArgs(netem))
 	cmd := bpm.DefaultProcessBuilder("tc", strings.Split(args, " ")...).SetNetNS(c.nsPath).SetContext(c.ctx).Build()
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -282,9 +418,9 @@ func (c *tcClient) addNetem(parent string, handle string, netem *pb.Netem) error
 }
 
 func (c *tcClient) addTbf(parent string, handle string, tbf *pb.Tbf) error {
-	log.Info("adding tbf", zap.String("parent", parent), zap.String("handle", handle))
+	log.Info("adding tbf", zap.String("parent", parent), zap.String("handle", handle), zap.String("device", c.device))
 
-	args := fmt.Sprintf("qdisc add dev eth0 %s %s tbf %s", parent, handle, convertTbfToArgs(tbf))
+	args := fmt.Sprintf("qdisc add dev %s %s %s tbf %s", c.device, parent, handle, convertTbfToArgs(tbf))
 	cmd := bpm.DefaultProcessBuilder("tc", strings.Split(args, " ")...).SetNetNS(c.nsPath).SetContext(c.ctx).Build()
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -294,9 +430,9 @@ func (c *tcClient) addTbf(parent string, handle string, tbf *pb.Tbf) error {
 }
 
 func (c *tcClient) addFilter(parent string, classid string, ipset string) error {
-	log.Info("adding filter", zap.String("parent", parent), zap.String("classid", classid), zap.String("ipset", ipset))
+	log.Info("adding filter", zap.String("parent", parent), zap.String("classid", classid), zap.String("ipset", ipset), zap.String("device", c.device))
 
-	args := strings.Split(fmt.Sprintf("filter add dev eth0 %s basic match", parent), " ")
+	args := strings.Split(fmt.Sprintf("filter add dev %s %s basic match", c.device, parent), " ")
 	args = append(args, fmt.Sprintf("ipset(%s dst)", ipset))
 	args = append(args, strings.Split(classid, " ")...)
 	cmd := bpm.DefaultProcessBuilder("tc", args...).SetNetNS(c.nsPath).SetContext(c.ctx).Build()
@@ -0,0 +1,87 @@
+// Copyright 2020 Chaos Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package chaosd
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	pb "github.com/chaos-mesh/chaos-daemon/pkg/server/serverpb"
+)
+
+// TestSetDeviceTcRulesStacksMultipleTcOnOneIpset is an integration test that
+// requires root and a host with iproute2 + ipset installed. It stacks a NETEM
+// delay qdisc and a TBF rate-limit qdisc behind a single ipset filter and
+// checks that both qdiscs end up in the resulting tree, instead of only the
+// first one as it used to be before each ipset owned its own qdisc chain.
+func TestSetDeviceTcRulesStacksMultipleTcOnOneIpset(t *testing.T) {
+	if os.Getenv("CHAOSD_INTEGRATION_TEST") == "" {
+		t.Skip("set CHAOSD_INTEGRATION_TEST=1 to run; requires root and iproute2/ipset")
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("requires root to manipulate tc/ipset")
+	}
+
+	const ipsetName = "chaosd-test-ipset"
+	const device = "lo"
+
+	if out, err := exec.Command("ipset", "create", ipsetName, "hash:ip").CombinedOutput(); err != nil {
+		t.Fatalf("failed to create ipset: %v: %s", err, out)
+	}
+	defer exec.Command("ipset", "destroy", ipsetName).Run() // nolint: errcheck
+
+	if out, err := exec.Command("ipset", "add", ipsetName, "127.0.0.1").CombinedOutput(); err != nil {
+		t.Fatalf("failed to add entry to ipset: %v: %s", err, out)
+	}
+
+	ctx := context.Background()
+	defer buildTcClient(ctx, "", device).flush() // nolint: errcheck
+
+	in := &pb.TcsRequest{
+		Tcs: []*pb.Tc{
+			{
+				Type:  pb.Tc_NETEM,
+				Ipset: ipsetName,
+				Netem: &pb.Netem{Time: 50000},
+			},
+			{
+				Type:  pb.Tc_BANDWIDTH,
+				Ipset: ipsetName,
+				Tbf:   &pb.Tbf{Rate: 1000, Buffer: 1600, Limit: 3000},
+			},
+		},
+	}
+
+	if err := setDeviceTcRules(ctx, "", device, in); err != nil {
+		t.Fatalf("failed to set tc rules: %v", err)
+	}
+
+	out, err := exec.Command("tc", "qdisc", "show", "dev", device).CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed to show qdiscs: %v: %s", err, out)
+	}
+
+	output := string(out)
+	for _, want := range []string{"netem", "tbf", "sfq"} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("expected chained netem+tbf+sfq qdiscs on %s, got:\n%s", device, output)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2020 Chaos Mesh Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chaosd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	nl "github.com/chaos-mesh/chaosd/pkg/netlink"
+
+	pb "github.com/chaos-mesh/chaos-daemon/pkg/server/serverpb"
+)
+
+// netlinkBackend adapts pkg/netlink.Client to the qdiscBackend surface, so it
+// is a drop-in replacement for tcClient. It translates the "parent 8:4" /
+// "handle 9:" style arguments the shell backend builds into typed
+// nl.Handle values.
+type netlinkBackend struct {
+	cli *nl.Client
+}
+
+func newNetlinkBackend(nsPath string, device string) *netlinkBackend {
+	return &netlinkBackend{cli: nl.NewClient(nsPath, device)}
+}
+
+func (b *netlinkBackend) flush() error {
+	if err := b.cli.Flush(); err != nil && !errors.Is(err, nl.ErrQdiscNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (b *netlinkBackend) addTc(parentArg string, handleArg string, tc *pb.Tc) error {
+	parent, err := parseHandleArg(parentArg)
+	if err != nil {
+		return err
+	}
+	handle, err := parseHandleArg(handleArg)
+	if err != nil {
+		return err
+	}
+
+	switch tc.Type {
+	case pb.Tc_NETEM:
+		if tc.Netem == nil {
+			return fmt.Errorf("netem is nil while type is NETEM")
+		}
+		return b.cli.AddNetem(parent, handle, netemToNetlinkParams(tc.Netem))
+	case pb.Tc_BANDWIDTH:
+		if tc.Tbf == nil {
+			return fmt.Errorf("tbf is nil while type is BANDWIDTH")
+		}
+		return b.cli.AddTbf(parent, handle, tbfToNetlinkParams(tc.Tbf))
+	default:
+		return fmt.Errorf("unknown tc qdisc type")
+	}
+}
+
+func (b *netlinkBackend) addPrio(parent int, band int) error {
+	parentHandle := nl.RootHandle
+	if parent > 0 {
+		parentHandle = nl.Handle{Major: uint16(parent), Minor: 0}
+	}
+
+	prioHandle := nl.Handle{Major: uint16(parent + 1), Minor: 0}
+
+	return b.cli.AddPrioWithSfq(parentHandle, prioHandle, band)
+}
+
+func (b *netlinkBackend) addSfq(parentArg string, handleArg string) error {
+	parent, err := parseHandleArg(parentArg)
+	if err != nil {
+		return err
+	}
+	handle, err := parseHandleArg(handleArg)
+	if err != nil {
+		return err
+	}
+
+	return b.cli.AddSfq(parent, handle)
+}
+
+// addChain adds the whole ipset qdisc chain through a single nl.Client.AddChain
+// call, i.e. a single netns switch, instead of one addTc/addSfq call (and
+// netns switch) per qdisc in the chain.
+func (b *netlinkBackend) addChain(parentArg string, tcs []*pb.Tc, startHandle int) (int, error) {
+	parent, err := parseHandleArg(parentArg)
+	if err != nil {
+		return startHandle, err
+	}
+
+	currentHandler := startHandle
+	qdiscs := make([]nl.ChainQdisc, 0, len(tcs))
+
+	for _, tc := range tcs {
+		currentHandler++
+		handle := nl.Handle{Major: uint16(currentHandler), Minor: 0}
+
+		switch tc.Type {
+		case pb.Tc_NETEM:
+			if tc.Netem == nil {
+				return currentHandler, fmt.Errorf("netem is nil while type is NETEM")
+			}
+			params := netemToNetlinkParams(tc.Netem)
+			qdiscs = append(qdiscs, nl.ChainQdisc{Handle: handle, Netem: &params})
+		case pb.Tc_BANDWIDTH:
+			if tc.Tbf == nil {
+				return currentHandler, fmt.Errorf("tbf is nil while type is BANDWIDTH")
+			}
+			params := tbfToNetlinkParams(tc.Tbf)
+			qdiscs = append(qdiscs, nl.ChainQdisc{Handle: handle, Tbf: &params})
+		default:
+			return currentHandler, fmt.Errorf("unknown tc qdisc type")
+		}
+	}
+
+	currentHandler++
+	leafHandle := nl.Handle{Major: uint16(currentHandler), Minor: 0}
+
+	if err := b.cli.AddChain(parent, qdiscs, leafHandle); err != nil {
+		return currentHandler, err
+	}
+
+	return currentHandler, nil
+}
+
+// parseHandleArg parses the "root", "parent 8:4" and "handle 9:" style
+// arguments tcClient builds for the shell backend into a typed nl.Handle.
+func parseHandleArg(arg string) (nl.Handle, error) {
+	fields := strings.Fields(arg)
+
+	if len(fields) == 1 && fields[0] == "root" {
+		return nl.RootHandle, nil
+	}
+	if len(fields) != 2 {
+		return nl.Handle{}, fmt.Errorf("unexpected tc handle argument %q", arg)
+	}
+
+	parts := strings.SplitN(fields[1], ":", 2)
+	major, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return nl.Handle{}, fmt.Errorf("invalid handle %q", arg)
+	}
+
+	var minor uint64
+	if len(parts) == 2 && parts[1] != "" {
+		minor, err = strconv.ParseUint(parts[1], 10, 16)
+		if err != nil {
+			return nl.Handle{}, fmt.Errorf("invalid handle %q", arg)
+		}
+	}
+
+	return nl.Handle{Major: uint16(major), Minor: uint16(minor)}, nil
+}
+
+// netemToNetlinkParams mirrors convertNetemToArgs' field gating (e.g. jitter
+// and reordering only ever apply alongside a delay) so the netlink and shell
+// backends produce the same netem configuration for the same request.
+func netemToNetlinkParams(netem *pb.Netem) nl.NetemParams {
+	params := nl.NetemParams{
+		Limit:         uint32(netem.Limit),
+		Loss:          float32(netem.Loss),
+		LossCorr:      float32(netem.LossCorr),
+		Duplicate:     float32(netem.Duplicate),
+		DuplicateCorr: float32(netem.DuplicateCorr),
+		Corrupt:       float32(netem.Corrupt),
+		CorruptCorr:   float32(netem.CorruptCorr),
+	}
+
+	if netem.Time > 0 {
+		params.Latency = uint32(netem.Time)
+		params.Jitter = uint32(netem.Jitter)
+		if netem.Jitter > 0 {
+			params.DelayCorr = float32(netem.DelayCorr)
+		}
+
+		if netem.Reorder > 0 {
+			params.Reorder = float32(netem.Reorder)
+			params.ReorderCorr = float32(netem.ReorderCorr)
+			params.Gap = uint32(netem.Gap)
+		}
+	}
+
+	return params
+}
+
+func tbfToNetlinkParams(tbf *pb.Tbf) nl.TbfParams {
+	return nl.TbfParams{
+		Rate:     uint64(tbf.Rate),
+		Buffer:   uint32(tbf.Buffer),
+		Limit:    uint32(tbf.Limit),
+		PeakRate: uint64(tbf.PeakRate),
+		MinBurst: uint32(tbf.MinBurst),
+	}
+}